@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"time"
 
 	"aqwari.net/net/styx/internal/qidpool"
 	"aqwari.net/net/styx/internal/styxfile"
@@ -21,6 +22,8 @@ var (
 	errTagInUse     = errors.New("tag in use")
 	errNoFid        = errors.New("no such fid")
 	errNotSupported = errors.New("not supported")
+	errNeedTversion = errors.New("need Tversion")
+	errSmallMsize   = errors.New("buffer too small")
 )
 
 type fcall interface {
@@ -76,6 +79,10 @@ type conn struct {
 	// used to implement request cancellation when a Tflush
 	// message is received.
 	pendingReq *threadsafe.Map
+
+	// Set when the server ends the connection because the
+	// client violated the protocol.
+	err error
 }
 
 func (c *conn) remoteAddr() net.Addr {
@@ -174,17 +181,26 @@ func (c *conn) clearTag(tag uint16) bool {
 	return false
 }
 
+// Any timeout requested for a message is measured from the
+// moment the message is read from the connection.
+func (c *conn) requestTimeout(m styxproto.Msg) (time.Duration, bool) {
+	if c.srv.RequestTimeout == nil {
+		return 0, false
+	}
+	return c.srv.RequestTimeout(m)
+}
+
 // runs in its own goroutine, one per connection.
 func (c *conn) serve() {
+	// report the close once the connection is torn down
+	defer c.reportClose()
 	defer c.close()
 
-	if !c.acceptTversion() {
-		return
-	}
-
-	for c.Next() && c.Encoder.Err() == nil {
-		if !c.handleMessage(c.Msg()) {
-			break
+	if c.acceptTversion() {
+		for c.Next() && c.Encoder.Err() == nil {
+			if !c.handleMessage(c.Msg()) {
+				break
+			}
 		}
 	}
 	if err := c.Encoder.Err(); err != nil {
@@ -193,12 +209,52 @@ func (c *conn) serve() {
 	c.srv.logf("closed connection from %s", c.remoteAddr())
 }
 
+// reportClose tells the Server why a connection ended. A clean
+// EOF from the client is not an error. A truncated message, or one
+// whose size cannot be trusted, means the client is not speaking 9P
+// correctly, and is reported separately from transport errors.
+func (c *conn) reportClose() {
+	err := c.err
+	if err == nil {
+		err = c.Decoder.Err()
+	}
+	if err == nil {
+		err = c.Encoder.Err()
+	}
+	if isProtocolError(err) {
+		c.srv.logf("protocol error from %s: %s", c.remoteAddr(), err)
+		if c.srv.OnProtocolError != nil {
+			c.srv.OnProtocolError(c.remoteAddr(), err)
+		}
+	} else if c.srv.OnDisconnect != nil {
+		c.srv.OnDisconnect(c.remoteAddr(), err)
+	}
+}
+
+func isProtocolError(err error) bool {
+	switch err {
+	case io.ErrUnexpectedEOF, styxproto.ErrMaxSize, styxproto.ErrBadSize,
+		styxproto.ErrShortRead, errTagInUse, errNeedTversion, errSmallMsize:
+		return true
+	}
+	return false
+}
+
 func (c *conn) handleMessage(m styxproto.Msg) bool {
 	if _, ok := c.pendingReq.Get(m.Tag()); ok {
 		c.srv.logf("fatal: client re-used existing tag %d", m.Tag())
+		c.err = errTagInUse
 		return false
 	}
-	ctx, cancel := context.WithCancel(c.ctx)
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if d, ok := c.requestTimeout(m); ok {
+		ctx, cancel = context.WithTimeout(c.ctx, d)
+	} else {
+		ctx, cancel = context.WithCancel(c.ctx)
+	}
 	c.pendingReq.Put(m.Tag(), cancel)
 
 	switch m := m.(type) {
@@ -232,12 +288,14 @@ func (c *conn) acceptTversion() bool {
 	for c.Next() && c.Encoder.Err() == nil {
 		tver, ok := c.Msg().(styxproto.Tversion)
 		if !ok {
-			c.Rerror(tver.Tag(), "need Tversion")
+			c.err = errNeedTversion
+			c.Rerror(c.Msg().Tag(), "%s", c.err)
 			break
 		}
 		msize := tver.Msize()
 		if msize < styxproto.MinBufSize {
-			c.Rerror(tver.Tag(), "buffer too small")
+			c.err = errSmallMsize
+			c.Rerror(tver.Tag(), "%s", c.err)
 			break
 		}
 		if msize < c.msize {
@@ -10,6 +10,10 @@ type file struct {
 	rwc  styxfile.Interface
 	name string
 
+	// If the value passed to Ropen or Rcreate implements
+	// ReadAuthorizer, it is consulted before each read.
+	readAuth ReadAuthorizer
+
 	// This is an afid, used for authentication
 	auth bool
 }
@@ -42,3 +46,55 @@ type OwnerInfo interface {
 type Directory interface {
 	Readdir(n int) ([]os.FileInfo, error)
 }
+
+// If the value passed to the Ropen or Rcreate methods implements the
+// ReadAuthorizer interface, the styx package will call its AuthorizeRead
+// method before serving each read request on the opened file, with the
+// Session the read belongs to and the offset requested by the client.
+// If AuthorizeRead returns a non-nil error, the read is not performed,
+// and the error is sent to the client instead. This allows for access
+// checks that may change over the lifetime of an open file, such as
+// revoked permissions or content that is only partially available to
+// a user.
+type ReadAuthorizer interface {
+	AuthorizeRead(s *Session, offset int64) error
+}
+
+// A SwapFile holds the contents of a file that must never be seen
+// partially written, such as a configuration file. Clients write the
+// full new contents of the file, which are swapped in atomically when
+// the client clunks (closes) its handle. Until then, reads through any
+// handle return the previous contents. If the file is closed for any
+// other reason, such as the end of a session, the written contents
+// are discarded.
+//
+// Writes start from empty contents, not the existing ones, and must
+// begin at offset 0; a write that would leave a gap fails. Appending
+// to a SwapFile, or overwriting only part of it, is not possible.
+type SwapFile struct {
+	swap *styxfile.Swap
+}
+
+// NewSwapFile creates a SwapFile with a copy of data as its initial
+// contents. If validate is not nil, it is called with the new contents
+// of the file before they are swapped in. If validate returns a non-nil
+// error, the contents of the file are unchanged, and the error is sent
+// to the client in response to its Tclunk request.
+func NewSwapFile(data []byte, validate func([]byte) error) *SwapFile {
+	return &SwapFile{styxfile.NewSwap(data, validate)}
+}
+
+// Open returns a new handle to the SwapFile, suitable for passing
+// to the Ropen or Rcreate methods. flag should be the Flag field of
+// the Topen or Tcreate request; if it includes os.O_TRUNC, the
+// contents of the file are replaced when the handle is clunked, even
+// if the client writes nothing.
+func (f *SwapFile) Open(flag int) interface{} {
+	return f.swap.Open(flag)
+}
+
+// Bytes returns the current contents of the SwapFile. The returned
+// slice must not be modified.
+func (f *SwapFile) Bytes() []byte {
+	return f.swap.Bytes()
+}
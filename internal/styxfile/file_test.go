@@ -112,3 +112,75 @@ func TestDirectory(t *testing.T) {
 		t.Logf("%s", stat)
 	}
 }
+
+func TestSwap(t *testing.T) {
+	swap := NewSwap([]byte("old"), nil)
+	file := swap.Open(os.O_WRONLY)
+	defer file.Close()
+
+	write(t, file, 0, "n")
+	write(t, file, 1, "ew")
+	compare(t, file, 0, "old")
+	if _, err := file.WriteAt([]byte("x"), 10); err == nil {
+		t.Error("write past end of staged data succeeded")
+	}
+	if err := Commit(file); err != nil {
+		t.Fatal(err)
+	}
+	compare(t, file, 0, "new")
+
+	// uncommitted writes are discarded on close
+	other := swap.Open(os.O_WRONLY)
+	write(t, other, 0, "discarded")
+	other.Close()
+	compare(t, file, 0, "new")
+}
+
+// Truncating without writing commits empty contents,
+// which are still validated.
+func TestSwapTruncate(t *testing.T) {
+	var validated bool
+	swap := NewSwap([]byte("old"), func(data []byte) error {
+		validated = true
+		if len(data) != 0 {
+			t.Errorf("validating %q, wanted empty contents", data)
+		}
+		return nil
+	})
+	trunc := swap.Open(os.O_WRONLY | os.O_TRUNC)
+	if err := Commit(trunc); err != nil {
+		t.Fatal(err)
+	}
+	trunc.Close()
+	if !validated {
+		t.Error("truncated contents were not validated")
+	}
+	if got := swap.Bytes(); len(got) != 0 {
+		t.Errorf("contents are %q after truncation, wanted empty", got)
+	}
+}
+
+func TestSwapCopy(t *testing.T) {
+	data := []byte("old")
+	swap := NewSwap(data, nil)
+	copy(data, "new")
+	if got := string(swap.Bytes()); got != "old" {
+		t.Errorf("contents changed to %q with caller's slice", got)
+	}
+}
+
+func TestSwapPartialWrite(t *testing.T) {
+	swap := NewSwap([]byte("old"), nil)
+	file := swap.Open(os.O_WRONLY)
+	defer file.Close()
+
+	// staged contents start empty, so offset 3 leaves a hole
+	if _, err := file.WriteAt([]byte("x"), 3); err != errSwapHole {
+		t.Errorf("append got error %v, wanted %v", err, errSwapHole)
+	}
+	write(t, file, 0, "ab")
+	if err := Commit(file); err != nil {
+		t.Fatal(err)
+	}
+	compare(t, file, 0, "ab")
+}
@@ -0,0 +1,126 @@
+package styxfile
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"sync"
+)
+
+var errSwapHole = errors.New("contents must be written in full from offset 0")
+
+// Some files, such as configuration files, must never be seen in a
+// partially written state. A Swap holds the contents of such a file.
+// Writes through a handle returned by Open are staged, and only replace
+// the contents of the Swap when the handle is committed; until then,
+// reads through any handle return the previous contents.
+
+// A Swap holds the contents of a file that is replaced atomically.
+type Swap struct {
+	validate func([]byte) error
+	mu       sync.RWMutex
+	data     []byte
+}
+
+// NewSwap creates a new Swap with a copy of data as its initial
+// contents. If validate is not nil, it is called with the staged
+// contents of a handle before they are committed. If validate
+// returns a non-nil error, the staged contents are discarded and
+// the contents of the Swap are unchanged.
+func NewSwap(data []byte, validate func([]byte) error) *Swap {
+	return &Swap{validate: validate, data: append([]byte{}, data...)}
+}
+
+// Bytes returns the current contents of the Swap. The returned
+// slice must not be modified.
+func (s *Swap) Bytes() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data
+}
+
+// Open returns a new handle to the Swap. Each handle stages its
+// writes separately, starting from empty contents rather than the
+// current ones: the new contents must be written in full, starting
+// at offset 0, so appending to or partially overwriting the Swap is
+// not possible. flag holds the flags the file was opened with; if it
+// includes os.O_TRUNC, the contents of the Swap are replaced when
+// the handle is committed, even if nothing was written.
+func (s *Swap) Open(flag int) Interface {
+	return &swapHandle{swap: s, dirty: flag&os.O_TRUNC != 0}
+}
+
+type swapHandle struct {
+	swap   *Swap
+	staged []byte
+	dirty  bool
+	sync.Mutex
+}
+
+func (h *swapHandle) ReadAt(p []byte, offset int64) (int, error) {
+	return bytes.NewReader(h.swap.Bytes()).ReadAt(p, offset)
+}
+
+// Writes past the end of the staged data, which would leave
+// holes, are not allowed.
+func (h *swapHandle) WriteAt(p []byte, offset int64) (int, error) {
+	h.Lock()
+	defer h.Unlock()
+
+	if offset < 0 || offset > int64(len(h.staged)) {
+		return 0, errSwapHole
+	}
+	if end := int(offset) + len(p); end > len(h.staged) {
+		h.staged = append(h.staged[:offset], p...)
+	} else {
+		copy(h.staged[offset:], p)
+	}
+	h.dirty = true
+	return len(p), nil
+}
+
+// Commit makes the staged contents of file visible, if file is a
+// handle returned by the Open method of a Swap. It does nothing for
+// any other file. The styx package calls Commit when a client clunks
+// an open file, before closing it; a handle that is closed without
+// being committed discards its staged contents.
+func Commit(file Interface) error {
+	if h, ok := file.(*swapHandle); ok {
+		return h.commit()
+	}
+	return nil
+}
+
+func (h *swapHandle) commit() error {
+	h.Lock()
+	defer h.Unlock()
+
+	if !h.dirty {
+		return nil
+	}
+	staged := h.staged
+	h.staged, h.dirty = nil, false
+
+	if h.swap.validate != nil {
+		if err := h.swap.validate(staged); err != nil {
+			return err
+		}
+	}
+	h.swap.mu.Lock()
+	h.swap.data = staged
+	h.swap.mu.Unlock()
+	return nil
+}
+
+func (h *swapHandle) Close() error {
+	h.Lock()
+	h.staged, h.dirty = nil, false
+	h.Unlock()
+	return nil
+}
+
+// Size returns the length of the committed contents, so that
+// clients see the file's current size when they stat it.
+func (h *swapHandle) Size() int64 {
+	return int64(len(h.swap.Bytes()))
+}
@@ -165,6 +165,7 @@ func (t Topen) Ropen(rwc interface{}, err error) {
 	}
 	t.session.files.Update(t.fid, &file, func() {
 		file.rwc = f
+		file.readAuth, _ = rwc.(ReadAuthorizer)
 	})
 	t.session.unhandled = false
 	if t.session.conn.clearTag(t.tag) {
@@ -276,6 +277,7 @@ func (t Tcreate) Rcreate(rwc interface{}, err error) {
 		return
 	}
 	file := file{name: path.Join(t.Path(), t.Name), rwc: f}
+	file.readAuth, _ = rwc.(ReadAuthorizer)
 
 	// fid for parent directory is now the fid for the new file,
 	// so there is no increase in references to this session.
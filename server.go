@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"aqwari.net/net/styx/internal/util"
+	"aqwari.net/net/styx/styxproto"
 	"aqwari.net/retry"
 )
 
@@ -47,6 +48,27 @@ type Server struct {
 	// OpenAuth is used to open file to authentication agent
 	OpenAuth AuthOpenFunc
 
+	// RequestTimeout, if not nil, is called with each incoming
+	// 9P message. If it returns true, the Context of the resulting
+	// request is cancelled d after the message is received. This
+	// allows a server to honor per-operation timeouts requested by
+	// clients, through the contents of a message. Handlers are
+	// expected to watch the Context of long-running requests; the
+	// styx package itself answers Tread and Twalk requests that
+	// time out with an error. Twrite requests are not cancelled.
+	RequestTimeout func(msg styxproto.Msg) (d time.Duration, ok bool)
+
+	// OnDisconnect, if not nil, is called when a connection ends
+	// without a protocol error. err is nil if the client closed the
+	// connection cleanly, and is the I/O error that ended the
+	// connection otherwise.
+	OnDisconnect func(addr net.Addr, err error)
+
+	// OnProtocolError, if not nil, is called instead of OnDisconnect
+	// when a connection is closed because the client sent a corrupt
+	// or truncated message, or otherwise violated the 9P protocol.
+	OnProtocolError func(addr net.Addr, err error)
+
 	// If not nil, ErrorLog will be used to log unexpected
 	// errors accepting or handling connections. TraceLog,
 	// if not nil, will receive detailed protocol tracing
@@ -2,14 +2,18 @@ package styx
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"os"
 	"path"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -34,6 +38,7 @@ func (t testLogger) Printf(format string, args ...interface{}) {
 type testServer struct {
 	callback func(req, rsp styxproto.Msg)
 	handler  Handler
+	timeout  func(styxproto.Msg) (time.Duration, bool)
 	test     *testing.T
 }
 
@@ -129,13 +134,25 @@ var _ styxfile.Directory = emptyDir{}
 
 func (d emptyDir) Readdir(int) ([]os.FileInfo, error) { return nil, nil }
 
-func chanServer(t *testing.T, handler Handler) (in, out chan styxproto.Msg) {
+// openHandler returns a Handler that lets clients walk to any
+// file, and opens the value returned by file.
+func openHandler(file func(Topen) interface{}) Handler {
+	return HandlerFunc(func(s *Session) {
+		for s.Next() {
+			switch req := s.Request().(type) {
+			case Twalk:
+				req.Rwalk(emptyStatFile(path.Base(req.Path())), nil)
+			case Topen:
+				req.Ropen(file(req), nil)
+			}
+		}
+	})
+}
+
+func chanServer(t *testing.T, srv Server) (in, out chan styxproto.Msg) {
 	var ln netutil.PipeListener
 	// last for one session
-	srv := Server{
-		Handler:  handler,
-		ErrorLog: testLogger{t},
-	}
+	srv.ErrorLog = testLogger{t}
 	go srv.Serve(&ln)
 	conn, err := ln.Dial()
 	if err != nil {
@@ -208,7 +225,10 @@ func (s testServer) run(r io.Reader) {
 		s.callback = func(q, r styxproto.Msg) {}
 	}
 	pending := make(map[uint16]styxproto.Msg)
-	requests, responses := chanServer(s.test, s.handler)
+	requests, responses := chanServer(s.test, Server{
+		Handler:        s.handler,
+		RequestTimeout: s.timeout,
+	})
 
 Loop:
 	for msg := range messagesFrom(s.test, r) {
@@ -680,3 +700,510 @@ func TestRootQid(t *testing.T) {
 		t.Error("test cases did not fire")
 	}
 }
+
+// A file whose contents are only readable up to a limit.
+type limitedFile struct {
+	*strings.Reader
+	limit int64
+}
+
+func (f limitedFile) Close() error                                { return nil }
+func (f limitedFile) WriteAt(p []byte, offset int64) (int, error) { return 0, styxfile.ErrNotSupported }
+func (f limitedFile) AuthorizeRead(s *Session, offset int64) error {
+	if offset >= f.limit {
+		return errors.New("payment required")
+	}
+	return nil
+}
+
+func TestReadAuthorizer(t *testing.T) {
+	const limit = 10
+	var allowed, denied int
+	srv := testServer{test: t}
+	srv.callback = func(req, rsp styxproto.Msg) {
+		tread, ok := req.(styxproto.Tread)
+		if !ok {
+			return
+		}
+		switch rsp := rsp.(type) {
+		case styxproto.Rread:
+			if tread.Offset() >= limit {
+				t.Errorf("read at offset %d should have been denied", tread.Offset())
+			}
+			allowed++
+		case styxproto.Rerror:
+			if tread.Offset() < limit {
+				t.Errorf("read at offset %d denied: %s", tread.Offset(), rsp.Ename())
+			}
+			denied++
+		default:
+			t.Errorf("got %T response to %T", rsp, req)
+		}
+	}
+	srv.handler = openHandler(func(Topen) interface{} {
+		return limitedFile{
+			Reader: strings.NewReader("0123456789abcdefghij"),
+			limit:  limit,
+		}
+	})
+	srv.runMsg(func(enc *styxproto.Encoder) {
+		enc.Twalk(1, 0, 1, "article")
+		enc.Topen(1, 1, styxproto.OREAD)
+		enc.Tread(1, 1, 0, 5)
+		enc.Tread(1, 1, 5, 5)
+		enc.Tread(1, 1, 10, 5)
+		enc.Tread(1, 1, 15, 5)
+		enc.Tclunk(1, 1)
+	})
+	if allowed != 2 || denied != 2 {
+		t.Errorf("got %d allowed and %d denied reads, wanted 2 and 2",
+			allowed, denied)
+	}
+}
+
+type closeReport struct {
+	err      error
+	protocol bool
+}
+
+// dialServer starts a server, calls fn with a new connection to
+// it and closes the connection, returning how the server reported
+// the end of the connection.
+func dialServer(t *testing.T, fn func(conn net.Conn)) closeReport {
+	var ln netutil.PipeListener
+	reports := make(chan closeReport, 1)
+	srv := Server{
+		ErrorLog: testLogger{t},
+		OnDisconnect: func(addr net.Addr, err error) {
+			reports <- closeReport{err: err}
+		},
+		OnProtocolError: func(addr net.Addr, err error) {
+			reports <- closeReport{err: err, protocol: true}
+		},
+	}
+	go srv.Serve(&ln)
+	defer ln.Close()
+
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn(conn)
+	conn.Close()
+
+	select {
+	case r := <-reports:
+		return r
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout waiting for server to close connection")
+	}
+	return closeReport{}
+}
+
+// closeServer establishes a session, so that the connection is
+// closed while the server has open fids, then writes the output
+// of fn to the connection.
+func closeServer(t *testing.T, fn func(w io.Writer)) closeReport {
+	return dialServer(t, func(conn net.Conn) {
+		enc := styxproto.NewEncoder(conn)
+		dec := styxproto.NewDecoder(conn)
+		go func() {
+			enc.Tversion(styxproto.DefaultMaxSize, "9P2000")
+			enc.Tattach(1, 0, styxproto.NoFid, "", "")
+			enc.Twalk(2, 0, 1)
+			enc.Flush()
+		}()
+		for i := 0; i < 3; i++ {
+			if !dec.Next() {
+				t.Fatalf("session setup failed: %v", dec.Err())
+			}
+		}
+		fn(conn)
+	})
+}
+
+func TestCleanDisconnect(t *testing.T) {
+	r := closeServer(t, func(io.Writer) {})
+	if r.protocol || r.err != nil {
+		t.Errorf("clean close reported as protocol=%v err=%v", r.protocol, r.err)
+	}
+}
+
+func TestCorruptFrame(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame string
+		err   error
+	}{
+		{"short size", "\x04\x00\x00\x00" + "\x78\x01\x00", styxproto.ErrBadSize},
+		{"huge size", "\xff\xff\xff\xff" + "\x78\x01\x00", styxproto.ErrMaxSize},
+		{"truncated", "\x0f\x00\x00\x00" + "\x78\x01\x00\x00\x00", io.ErrUnexpectedEOF},
+		{"truncated Twrite",
+			"\x7b\x00\x00\x00" + "\x76\x01\x00" + "\x00\x00\x00\x00" +
+				"\x00\x00\x00\x00\x00\x00\x00\x00" + "\x64\x00\x00\x00" + "abc",
+			io.ErrUnexpectedEOF},
+		{"truncated bad Twrite",
+			"\x7b\x00\x00\x00" + "\x76\x01\x00" + "\x00\x00\x00\x00" +
+				"\x00\x00\x00\x00\x00\x00\x00\x00" + "\x32\x00\x00\x00" + "abc",
+			styxproto.ErrShortRead},
+	}
+	for _, tt := range tests {
+		r := closeServer(t, func(w io.Writer) {
+			io.WriteString(w, tt.frame)
+		})
+		if !r.protocol {
+			t.Errorf("%s: got disconnect (err=%v), wanted protocol error", tt.name, r.err)
+		} else if r.err != tt.err {
+			t.Errorf("%s: got protocol error %q, wanted %q", tt.name, r.err, tt.err)
+		}
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	const (
+		defaultTimeout = time.Hour
+		hintTimeout    = time.Millisecond * 100
+	)
+	deadlines := make(map[string]time.Duration)
+	srv := testServer{test: t}
+
+	// Clients ask for a shorter timeout by prefixing the
+	// name of a file with "!".
+	srv.timeout = func(msg styxproto.Msg) (time.Duration, bool) {
+		if m, ok := msg.(styxproto.Twalk); ok && m.Nwname() > 0 {
+			if bytes.HasPrefix(m.Wname(0), []byte("!")) {
+				return hintTimeout, true
+			}
+		}
+		return defaultTimeout, true
+	}
+	srv.handler = HandlerFunc(func(s *Session) {
+		for s.Next() {
+			switch req := s.Request().(type) {
+			case Twalk:
+				if d, ok := req.Context().Deadline(); !ok {
+					t.Errorf("Twalk %s has no deadline", req.Path())
+				} else {
+					deadlines[req.Path()] = time.Until(d)
+				}
+				req.Rwalk(emptyStatFile(path.Base(req.Path())), nil)
+			}
+		}
+	})
+	srv.runMsg(func(enc *styxproto.Encoder) {
+		enc.Twalk(1, 0, 1, "slow")
+		enc.Twalk(2, 0, 2, "!fast")
+	})
+	if d := deadlines["/slow"]; d <= hintTimeout || d > defaultTimeout {
+		t.Errorf("unhinted walk has deadline in %s, wanted at most %s",
+			d, defaultTimeout)
+	}
+	if d := deadlines["/!fast"]; d <= 0 || d > hintTimeout {
+		t.Errorf("hinted walk has deadline in %s, wanted at most %s",
+			d, hintTimeout)
+	}
+}
+
+func TestSwapFile(t *testing.T) {
+	config := NewSwapFile([]byte("old"), func(data []byte) error {
+		if bytes.Contains(data, []byte("bad config")) {
+			return errors.New("invalid config")
+		}
+		return nil
+	})
+	// The test harness does not preserve the data in Rread
+	// messages, so the contents are told apart by their length.
+	reads := []int64{3, 5, 5, 0} // "old", "newer", "newer", ""
+	srv := testServer{test: t}
+	srv.callback = func(req, rsp styxproto.Msg) {
+		switch req := req.(type) {
+		case styxproto.Tread:
+			rsp, ok := rsp.(styxproto.Rread)
+			if !ok {
+				t.Errorf("got %T response to %T", rsp, req)
+				return
+			}
+			if len(reads) == 0 {
+				t.Errorf("unexpected read of %d bytes", rsp.Count())
+				return
+			} else if rsp.Count() != reads[0] {
+				t.Errorf("read %d bytes, wanted %d", rsp.Count(), reads[0])
+			}
+			reads = reads[1:]
+		case styxproto.Tclunk:
+			_, isErr := rsp.(styxproto.Rerror)
+			if req.Fid() == 3 && !isErr {
+				t.Errorf("invalid config was accepted")
+			} else if req.Fid() != 3 && isErr {
+				t.Errorf("valid config was rejected: %s", rsp)
+			}
+		}
+	}
+	srv.handler = openHandler(func(req Topen) interface{} {
+		return config.Open(req.Flag)
+	})
+	srv.runMsg(func(enc *styxproto.Encoder) {
+		enc.Twalk(1, 0, 1, "config")
+		enc.Topen(1, 1, styxproto.OWRITE)
+		enc.Twalk(1, 0, 2, "config")
+		enc.Topen(1, 2, styxproto.OREAD)
+
+		enc.Twrite(1, 1, 0, []byte("newer"))
+		enc.Tread(1, 2, 0, 100)
+		enc.Tclunk(1, 1)
+		enc.Tread(1, 2, 0, 100)
+
+		enc.Twalk(1, 0, 3, "config")
+		enc.Topen(1, 3, styxproto.OWRITE)
+		enc.Twrite(1, 3, 0, []byte("bad config"))
+		enc.Tclunk(1, 3)
+		enc.Tread(1, 2, 0, 100)
+
+		enc.Twalk(1, 0, 4, "config")
+		enc.Topen(1, 4, styxproto.OWRITE|styxproto.OTRUNC)
+		enc.Tclunk(1, 4)
+		enc.Tread(1, 2, 0, 100)
+	})
+	if len(reads) > 0 {
+		t.Errorf("missing reads of %d bytes", reads)
+	}
+	if got := config.Bytes(); len(got) != 0 {
+		t.Errorf("config is %q after truncation, wanted empty", got)
+	}
+}
+
+// A file whose reads take longer than a given delay.
+type delayedFile struct {
+	emptyFile
+	delay  time.Duration
+	closed int32
+}
+
+func (f *delayedFile) ReadAt(p []byte, offset int64) (int, error) {
+	time.Sleep(f.delay)
+	return 0, io.EOF
+}
+
+func (f *delayedFile) Close() error {
+	atomic.StoreInt32(&f.closed, 1)
+	return nil
+}
+
+func TestRequestTimeoutRead(t *testing.T) {
+	var timedOut bool
+	file := &delayedFile{delay: time.Millisecond * 300}
+	srv := testServer{test: t}
+	srv.timeout = func(msg styxproto.Msg) (time.Duration, bool) {
+		if _, ok := msg.(styxproto.Tread); ok {
+			return time.Millisecond * 50, true
+		}
+		return 0, false
+	}
+	srv.callback = func(req, rsp styxproto.Msg) {
+		if _, ok := req.(styxproto.Tread); ok {
+			if _, ok := rsp.(styxproto.Rerror); !ok {
+				t.Errorf("got %T response to timed out %T", rsp, req)
+			}
+			if atomic.LoadInt32(&file.closed) != 0 {
+				t.Error("file was closed when its read timed out")
+			}
+			timedOut = true
+		}
+	}
+	srv.handler = openHandler(func(Topen) interface{} {
+		return file
+	})
+	srv.runMsg(func(enc *styxproto.Encoder) {
+		enc.Twalk(1, 0, 1, "slow")
+		enc.Topen(1, 1, styxproto.OREAD)
+		enc.Tread(1, 1, 0, 100)
+	})
+	if !timedOut {
+		t.Error("timed out Tread was not answered")
+	}
+}
+
+func TestBadVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(*styxproto.Encoder)
+		err  error
+	}{
+		{"no Tversion", func(enc *styxproto.Encoder) {
+			enc.Tattach(1, 0, styxproto.NoFid, "", "")
+		}, errNeedTversion},
+		{"small msize", func(enc *styxproto.Encoder) {
+			enc.Tversion(styxproto.MinBufSize-1, "9P2000")
+		}, errSmallMsize},
+	}
+	for _, tt := range tests {
+		r := dialServer(t, func(conn net.Conn) {
+			go io.Copy(ioutil.Discard, conn)
+			enc := styxproto.NewEncoder(conn)
+			tt.fn(enc)
+			enc.Flush()
+		})
+		if !r.protocol {
+			t.Errorf("%s: got disconnect (err=%v), wanted protocol error", tt.name, r.err)
+		} else if r.err != tt.err {
+			t.Errorf("%s: got protocol error %q, wanted %q", tt.name, r.err, tt.err)
+		}
+	}
+}
+
+// A user type that happens to have a Commit method.
+type commitFile struct{ emptyFile }
+
+func (commitFile) Commit() error { return errors.New("commit called") }
+
+func TestClunkIgnoresCommit(t *testing.T) {
+	srv := testServer{test: t}
+	srv.callback = func(req, rsp styxproto.Msg) {
+		if _, ok := req.(styxproto.Tclunk); ok {
+			if _, ok := rsp.(styxproto.Rclunk); !ok {
+				t.Errorf("got %s response to %T", rsp, req)
+			}
+		}
+	}
+	srv.handler = openHandler(func(Topen) interface{} {
+		return commitFile{}
+	})
+	srv.runMsg(func(enc *styxproto.Encoder) {
+		enc.Twalk(1, 0, 1, "file")
+		enc.Topen(1, 1, styxproto.OREAD)
+		enc.Tclunk(1, 1)
+	})
+}
+
+// A file that fails reads once its deadline has passed, like
+// a net.Conn.
+type deadlineFile struct {
+	emptyFile
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func (f *deadlineFile) SetDeadline(t time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deadline = t
+	return nil
+}
+
+func (f *deadlineFile) ReadAt(p []byte, offset int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.deadline.IsZero() && time.Now().After(f.deadline) {
+		return 0, errors.New("i/o timeout")
+	}
+	return copy(p, "ok"), nil
+}
+
+func TestRequestTimeoutCleared(t *testing.T) {
+	srv := testServer{test: t}
+
+	// only the first read, at offset 0, has a deadline
+	srv.timeout = func(msg styxproto.Msg) (time.Duration, bool) {
+		if m, ok := msg.(styxproto.Tread); ok && m.Offset() == 0 {
+			return time.Millisecond * 50, true
+		}
+		return 0, false
+	}
+	srv.callback = func(req, rsp styxproto.Msg) {
+		if _, ok := req.(styxproto.Tread); ok {
+			if _, ok := rsp.(styxproto.Rread); !ok {
+				t.Errorf("got %s response to %s", rsp, req)
+			}
+		}
+	}
+	srv.handler = openHandler(func(Topen) interface{} {
+		return &deadlineFile{}
+	})
+	srv.runMsg(func(enc *styxproto.Encoder) {
+		enc.Twalk(1, 0, 1, "conn")
+		enc.Topen(1, 1, styxproto.OREAD)
+		enc.Tread(1, 1, 0, 100)
+		enc.Flush()
+
+		// outlive the first read's deadline
+		time.Sleep(time.Millisecond * 100)
+		enc.Tread(1, 1, 1, 100)
+	})
+}
+
+func TestRequestTimeoutWalk(t *testing.T) {
+	srv := testServer{test: t}
+	srv.timeout = func(msg styxproto.Msg) (time.Duration, bool) {
+		return time.Millisecond * 50, true
+	}
+	srv.callback = func(req, rsp styxproto.Msg) {
+		if _, ok := req.(styxproto.Twalk); ok {
+			rerror, ok := rsp.(styxproto.Rerror)
+			if !ok {
+				t.Errorf("got %s response to timed out %s", rsp, req)
+			} else if !strings.Contains(string(rerror.Ename()), "deadline exceeded") {
+				t.Errorf("timed out walk got error %q", rerror.Ename())
+			}
+		}
+	}
+	srv.handler = HandlerFunc(func(s *Session) {
+		for s.Next() {
+			if req, ok := s.Request().(Twalk); ok {
+				<-req.Context().Done()
+			}
+		}
+	})
+	srv.runMsg(func(enc *styxproto.Encoder) {
+		enc.Twalk(1, 0, 1, "slow")
+	})
+}
+
+// Pending requests must be cancelled by the time the server
+// reports the end of a connection.
+func TestDisconnectAfterTeardown(t *testing.T) {
+	var ln netutil.PipeListener
+	pending := make(chan context.Context, 1)
+	reported := make(chan error, 1)
+	srv := Server{
+		ErrorLog: testLogger{t},
+		Handler: HandlerFunc(func(s *Session) {
+			for s.Next() {
+				if req, ok := s.Request().(Tstat); ok {
+					pending <- req.Context()
+					<-req.Context().Done()
+				}
+			}
+		}),
+	}
+	var ctx context.Context
+	srv.OnDisconnect = func(addr net.Addr, err error) {
+		if ctx.Err() == nil {
+			reported <- errors.New("request still pending when disconnect was reported")
+		}
+		close(reported)
+	}
+	go srv.Serve(&ln)
+	defer ln.Close()
+
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go io.Copy(ioutil.Discard, conn)
+	enc := styxproto.NewEncoder(conn)
+	enc.Tversion(styxproto.DefaultMaxSize, "9P2000")
+	enc.Tattach(1, 0, styxproto.NoFid, "", "")
+	enc.Tstat(2, 0)
+	enc.Flush()
+	ctx = <-pending
+	conn.Close()
+
+	select {
+	case err := <-reported:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout waiting for server to close connection")
+	}
+}
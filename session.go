@@ -302,6 +302,16 @@ func (s *Session) handleTread(ctx context.Context, msg styxproto.Tread, file fil
 	copy(msgCopy, msg)
 
 	go func(msg styxproto.Tread) {
+		if file.readAuth != nil {
+			if err := file.readAuth.AuthorizeRead(s, msg.Offset()); err != nil {
+				if s.conn.clearTag(msg.Tag()) {
+					s.conn.Rerror(msg.Tag(), "%v", err)
+					s.conn.Flush()
+				}
+				return
+			}
+		}
+
 		// TODO(droyo) allocations could hurt here, come up with a better
 		// way to do this (after measuring the impact, of course). The tricky bit
 		// here is inherent to the 9P protocol; rather than using sentinel values,
@@ -310,9 +320,10 @@ func (s *Session) handleTread(ctx context.Context, msg styxproto.Tread, file fil
 		// we don't know how much we are going to write until it's too late.
 		buf := make([]byte, int(msg.Count()))
 
-		if t, ok := ctx.Deadline(); ok {
-			styxfile.SetDeadline(file.rwc, t)
-		}
+		// A zero deadline clears any deadline set by a previous
+		// request on the same file.
+		t, _ := ctx.Deadline()
+		styxfile.SetDeadline(file.rwc, t)
 		done := make(chan struct{})
 		go func() {
 			n, err = file.rwc.ReadAt(buf, msg.Offset())
@@ -320,6 +331,15 @@ func (s *Session) handleTread(ctx context.Context, msg styxproto.Tread, file fil
 		}()
 		select {
 		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				// The request timed out, but the fid is still
+				// open and the client is owed a response.
+				if s.conn.clearTag(msg.Tag()) {
+					s.conn.Rerror(msg.Tag(), "%v", ctx.Err())
+					s.conn.Flush()
+				}
+				return
+			}
 			// NOTE(droyo) deciding what to do here is somewhat
 			// difficult. Many (but not all) Read/Write calls in Go can
 			// be interrupted by calling Close. Obviously, calling Close
@@ -371,7 +391,13 @@ func (s *Session) handleTclunk(ctx context.Context, msg styxproto.Tclunk, file f
 	s.conn.clearTag(msg.Tag())
 	s.files.Del(msg.Fid())
 	if file.rwc != nil {
-		if err := file.rwc.Close(); err != nil {
+		// Staged changes are only committed on an explicit clunk;
+		// files closed for any other reason discard them.
+		err := styxfile.Commit(file.rwc)
+		if cerr := file.rwc.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
 			s.conn.Rerror(msg.Tag(), "close %s: %v", file.name, err)
 		} else {
 			s.conn.Rclunk(msg.Tag())
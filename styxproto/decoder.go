@@ -87,8 +87,10 @@ func (s *Decoder) Reset(r io.Reader) {
 //
 // Invalid messages are not considered errors, and are
 // represented in the Messages slice as values of type BadMessage.
-// Only problems with the underlying io.Reader are
-// considered errors.
+// Problems with the underlying io.Reader are considered errors,
+// as are messages that break the framing of the stream, so that
+// the start of the next message cannot be found: ErrBadSize,
+// ErrMaxSize, ErrShortRead, and io.ErrUnexpectedEOF.
 func (s *Decoder) Err() error {
 	if s.err == io.EOF {
 		return nil
@@ -118,7 +120,9 @@ func (s *Decoder) Next() bool {
 		if r, ok := s.msg.(io.Reader); ok {
 			_, s.err = io.Copy(ioutil.Discard, r)
 		}
-		s.err = discard(s.br, s.msg.nbytes())
+		if err := discard(s.br, s.msg.nbytes()); s.err == nil {
+			s.err = err
+		}
 		s.msg = nil
 	}
 	if s.err != nil {
@@ -193,6 +197,10 @@ func (s *Decoder) fill(n int) error {
 		return errFillOverflow
 	}
 	_, err := s.br.Peek(s.pos + n)
+	if err == io.EOF && s.br.Buffered() > 0 {
+		// the stream ended in the middle of a message
+		return io.ErrUnexpectedEOF
+	}
 	return err
 }
 
@@ -27,10 +27,20 @@ var (
 	errTooBig         = parseError("message is too long")
 	errTooSmall       = parseError("message is too small")
 	errUnderSize      = parseError("empty space in message")
-	errZeroLen        = parseError("zero-length message")
 )
 
 // ErrMaxSize is returned during the parsing process if a message
 // exceeds the maximum size negotiated during the Tversion/Rversion
 // transaction.
 var ErrMaxSize = errors.New("message exceeds msize")
+
+// ErrBadSize is returned during the parsing process if the size field
+// of a message is too small to hold a 9P message header. Because 9P
+// messages are delimited only by their size field, the start of the
+// next message cannot be found, and the stream cannot be parsed further.
+var ErrBadSize = errors.New("message size smaller than header")
+
+// ErrShortRead is returned during the parsing process if an invalid
+// message is not fully buffered. Rather than perform additional I/O
+// to skip past the message, the Decoder stops parsing the stream.
+var ErrShortRead = errors.New("not enough data in buffer to complete message")
@@ -45,3 +45,25 @@ func testInvalidMsg(t *testing.T, r io.Reader) {
 		t.Logf("parsed %T", d.Msg())
 	}
 }
+
+func TestCorruptSize(t *testing.T) {
+	tests := []struct {
+		input string
+		err   error
+	}{
+		{"", nil},
+		{"\x07\x00\x00\x00\x6b\x01", io.ErrUnexpectedEOF},
+		{"\x13\x00\x00\x00\x78\x01\x00\x00\x20", io.ErrUnexpectedEOF},
+		{"\x03\x00\x00\x00\x6b\x01\x00", ErrBadSize},
+		{"\x00\x00\x00\x00\x6b\x01\x00", ErrBadSize},
+	}
+	for _, tt := range tests {
+		d := NewDecoder(strings.NewReader(tt.input))
+		for d.Next() {
+			t.Errorf("%q: parsed %T", tt.input, d.Msg())
+		}
+		if d.Err() != tt.err {
+			t.Errorf("%q: got error %v, wanted %v", tt.input, d.Err(), tt.err)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package styxproto
 
 import (
 	"bytes"
-	"errors"
 	"io"
 )
 
@@ -36,10 +35,6 @@ var msgParseLUT = [...]func(msg, io.Reader) (Msg, error){
 	msgRwstat:   parseRwstat,
 }
 
-var (
-	errShortRead = errors.New("not enough data in buffer to complete message")
-)
-
 // read just enough data to figure out the type of message and if
 // it's the right size.
 func (s *Decoder) nextHeader() (msg, error) {
@@ -55,6 +50,9 @@ func (s *Decoder) fetchMessage() (Msg, error) {
 		return nil, err
 	}
 
+	if dot.Len() < minMsgSize {
+		return nil, ErrBadSize
+	}
 	if err := verifySizeAndType(dot); err != nil {
 		return s.badMessage(dot, err)
 	}
@@ -142,11 +140,8 @@ func (s *Decoder) badMessage(bad msg, reason error) (Msg, error) {
 		tag:    bad.Tag(),
 		length: length,
 	}
-	if length == 0 {
-		return nil, errZeroLen
-	}
 	if int64(s.buflen()+s.dotlen()) < length {
-		return nil, errShortRead
+		return nil, ErrShortRead
 	}
 	// We can still continue parsing. This prevents one bad client
 	// from hurting performance for others on the same connection.
@@ -336,7 +331,7 @@ func parseRread(dot msg, r io.Reader) (Msg, error) {
 	if int64(len(buffered)) < count {
 		m.r = io.MultiReader(
 			m.r,
-			io.LimitReader(r, count-int64(len(buffered))))
+			&bodyReader{r, count - int64(len(buffered))})
 	}
 
 	return m, nil
@@ -366,7 +361,7 @@ func parseTwrite(dot msg, r io.Reader) (Msg, error) {
 	if int64(len(buffered)) < count {
 		m.r = io.MultiReader(
 			m.r,
-			io.LimitReader(r, count-int64(len(buffered))))
+			&bodyReader{r, count - int64(len(buffered))})
 	}
 
 	return m, nil
@@ -421,3 +416,26 @@ func parseTwstat(dot msg, _ io.Reader) (Msg, error) {
 func parseRwstat(dot msg, _ io.Reader) (Msg, error) {
 	return Rwstat(dot), nil
 }
+
+// A bodyReader reads the unbuffered portion of the data field of
+// a Twrite or Rread message. Unlike an io.LimitReader, it reports
+// a stream that ends before the message does as io.ErrUnexpectedEOF.
+type bodyReader struct {
+	r io.Reader
+	n int64
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.n {
+		p = p[:b.n]
+	}
+	n, err := b.r.Read(p)
+	b.n -= int64(n)
+	if err == io.EOF && b.n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
@@ -122,7 +122,9 @@ Loop:
 		return
 	}
 	if len(w.found) == 0 {
-		if err != nil {
+		if w.ctx.Err() == context.DeadlineExceeded {
+			w.session.conn.Rerror(w.tag, "%s", w.ctx.Err())
+		} else if err != nil {
 			w.session.conn.Rerror(w.tag, "%s", err)
 		} else {
 			w.session.conn.Rerror(w.tag, "No such file or directory")